@@ -17,7 +17,9 @@ limitations under the License.
 package configtx
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/hyperledger/fabric/common/config"
 	"github.com/hyperledger/fabric/common/configtx/api"
@@ -27,6 +29,11 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
+// defaultMaxConcurrentProposals is the fan-out width used when a
+// configManager's maxConcurrentProposals is left unset. A width of 1
+// reproduces the historical fully-serial behavior of proposeGroup.
+const defaultMaxConcurrentProposals = 1
+
 type configGroupWrapper struct {
 	*cb.ConfigGroup
 	deserializedValues map[string]proto.Message
@@ -40,16 +47,42 @@ func newConfigGroupWrapper(group *cb.ConfigGroup) *configGroupWrapper {
 }
 
 type configResult struct {
-	tx            interface{}
-	handler       api.Transactional
-	policyHandler api.Transactional
-	subResults    []*configResult
+	name               string
+	tx                 interface{}
+	handler            api.Transactional
+	policyHandler      api.Transactional
+	deserializedValues map[string]proto.Message
+	cm                 *configManager
+	// pendingRateLimit holds a deserialized rateLimitValueKey value from
+	// this group until commit, so that a proposal which is never
+	// committed (rejected, rolled back, or only ever simulated via
+	// SimulateConfig) never reconfigures cm's limiter.
+	pendingRateLimit interface{}
+	// subResults is ordered to match the subGroups order proposeGroup
+	// walked when it built this result, so preCommit/commit/rollback
+	// retrace that same DFS order instead of a randomized one. An entry
+	// is nil if a sibling error or a cancelled ctx aborted the walk
+	// before that slot was ever populated.
+	subResults []*configResult
+}
+
+// subResult returns this result's subgroup result named name, if any.
+func (cr *configResult) subResult(name string) (*configResult, bool) {
+	want := cr.name + "/" + name
+	for _, subResult := range cr.subResults {
+		if subResult != nil && subResult.name == want {
+			return subResult, true
+		}
+	}
+	return nil, false
 }
 
 func (cr *configResult) preCommit() error {
 	for _, subResult := range cr.subResults {
-		err := subResult.preCommit()
-		if err != nil {
+		if subResult == nil {
+			continue
+		}
+		if err := subResult.preCommit(); err != nil {
 			return err
 		}
 	}
@@ -58,14 +91,23 @@ func (cr *configResult) preCommit() error {
 
 func (cr *configResult) commit() {
 	for _, subResult := range cr.subResults {
+		if subResult == nil {
+			continue
+		}
 		subResult.commit()
 	}
 	cr.handler.CommitProposals(cr.tx)
 	cr.policyHandler.CommitProposals(cr.tx)
+	if cr.pendingRateLimit != nil {
+		cr.cm.applyChannelRateLimit(cr.pendingRateLimit)
+	}
 }
 
 func (cr *configResult) rollback() {
 	for _, subResult := range cr.subResults {
+		if subResult == nil {
+			continue
+		}
 		subResult.rollback()
 	}
 	cr.handler.RollbackProposals(cr.tx)
@@ -76,7 +118,18 @@ func (cr *configResult) rollback() {
 // it will in turn recursively call itself until all groups have been exhausted
 // at each call, it returns the handler that was passed in, plus any handlers returned
 // by recursive calls into proposeGroup
-func (cm *configManager) proposeGroup(tx interface{}, name string, group *configGroupWrapper, handler config.ValueProposer, policyHandler policies.Proposer) (*configResult, error) {
+//
+// name's direct subgroups are proposed concurrently, bounded per call by
+// cm.concurrencyLimit() (see maxConcurrentProposals); each recursive call
+// gets its own such pool, so the bound applies per level of the tree,
+// not to the walk as a whole. The first subgroup error cancels ctx so
+// that sibling proposals in flight can abort early,
+// and every subResult already produced by a sibling is rolled back before
+// the error is returned. preCommit is intentionally not invoked here: it
+// walks the result tree in DFS order and must run after the full tree has
+// been proposed, so callers (processConfig) invoke it once, sequentially,
+// on the returned result.
+func (cm *configManager) proposeGroup(ctx context.Context, tx interface{}, name string, group *configGroupWrapper, handler config.ValueProposer, policyHandler policies.Proposer) (*configResult, error) {
 	subGroups := make([]string, len(group.Groups))
 	i := 0
 	for subGroup := range group.Groups {
@@ -100,28 +153,92 @@ func (cm *configManager) proposeGroup(tx interface{}, name string, group *config
 	}
 
 	result := &configResult{
+		name:          name,
 		tx:            tx,
 		handler:       handler,
 		policyHandler: policyHandler,
-		subResults:    make([]*configResult, 0, len(subGroups)),
+		cm:            cm,
+		subResults:    make([]*configResult, len(subGroups)),
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// sem is scoped to this call, bounding fan-out among name's direct
+	// subgroups only. A semaphore shared across recursion levels would
+	// deadlock at the default concurrencyLimit of 1: the parent would
+	// hold the pool's only slot while blocked launching a child, and the
+	// child would in turn block forever trying to acquire that same
+	// slot to launch its own children.
+	sem := make(chan struct{}, cm.concurrencyLimit())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
 	for i, subGroup := range subGroups {
-		subResult, err := cm.proposeGroup(tx, name+"/"+subGroup, newConfigGroupWrapper(group.Groups[subGroup]), subHandlers[i], subPolicyHandlers[i])
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, subGroup string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			subResult, err := cm.proposeGroup(ctx, tx, name+"/"+subGroup, newConfigGroupWrapper(group.Groups[subGroup]), subHandlers[i], subPolicyHandlers[i])
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			result.subResults[i] = subResult
+		}(i, subGroup)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		for _, subResult := range result.subResults {
+			if subResult != nil {
+				subResult.rollback()
+			}
+		}
+		return nil, firstErr
+	}
+
+	if ctx.Err() != nil {
+		// ctx was cancelled by a caller, so some subgroups may never have
+		// been proposed; the tree is incomplete and is not returned.
+		result.rollback()
+		return nil, ctx.Err()
+	}
+
+	for key, value := range group.Values {
+		resolved, err := cm.resolveValue(value.Value)
 		if err != nil {
 			result.rollback()
 			return nil, err
 		}
-		result.subResults = append(result.subResults, subResult)
-	}
 
-	for key, value := range group.Values {
-		msg, err := valueDeserializer.Deserialize(key, value.Value)
+		msg, err := valueDeserializer.Deserialize(key, resolved)
 		if err != nil {
 			result.rollback()
 			return nil, err
 		}
 		group.deserializedValues[key] = msg
+
+		if key == rateLimitValueKey {
+			result.pendingRateLimit = msg
+		}
 	}
 
 	for key, policy := range group.Policies {
@@ -131,22 +248,66 @@ func (cm *configManager) proposeGroup(tx interface{}, name string, group *config
 		}
 	}
 
-	err = result.preCommit()
+	result.deserializedValues = group.deserializedValues
+
+	return result, nil
+}
+
+// concurrencyLimit returns the number of a single group's direct
+// subgroups proposeGroup is allowed to propose at once. A configManager
+// with maxConcurrentProposals of zero or one reproduces the historical
+// fully-serial walk: each level waits for its one child to fully return,
+// including that child's own (equally serial) recursion, before moving
+// on to the next.
+func (cm *configManager) concurrencyLimit() int {
+	if cm.maxConcurrentProposals < 1 {
+		return defaultMaxConcurrentProposals
+	}
+	return cm.maxConcurrentProposals
+}
+
+func (cm *configManager) processConfig(ctx context.Context, channelGroup *cb.ConfigGroup) (*configResult, error) {
+	txBytes, err := proto.Marshal(channelGroup)
 	if err != nil {
-		result.rollback()
 		return nil, err
 	}
+	size := len(txBytes)
 
-	return result, nil
-}
+	// The limiter is consulted, and its tokens spent, before the
+	// expensive recursive proposeGroup walk runs, so that an abusive
+	// peer spamming reconfiguration is throttled up front rather than
+	// after paying the cost of the walk. Those tokens stay spent even if
+	// the proposal is rejected or rolled back below.
+	if cm.limiter != nil {
+		if err := cm.limiter.Acquire(ctx, size); err != nil {
+			if cm.txMonitor != nil {
+				cm.txMonitor.recordRejected()
+			}
+			return nil, err
+		}
+	}
 
-func (cm *configManager) processConfig(channelGroup *cb.ConfigGroup) (*configResult, error) {
 	helperGroup := cb.NewConfigGroup()
 	helperGroup.Groups[RootGroupKey] = channelGroup
-	groupResult, err := cm.proposeGroup(channelGroup, "", newConfigGroupWrapper(helperGroup), cm.initializer.ValueProposer(), cm.initializer.PolicyProposer())
+	groupResult, err := cm.proposeGroup(ctx, channelGroup, "", newConfigGroupWrapper(helperGroup), cm.initializer.ValueProposer(), cm.initializer.PolicyProposer())
 	if err != nil {
+		if cm.txMonitor != nil {
+			cm.txMonitor.recordRejected()
+		}
 		return nil, err
 	}
 
+	if err := groupResult.preCommit(); err != nil {
+		groupResult.rollback()
+		if cm.txMonitor != nil {
+			cm.txMonitor.recordRejected()
+		}
+		return nil, err
+	}
+
+	if cm.txMonitor != nil {
+		cm.txMonitor.recordAccepted(size)
+	}
+
 	return groupResult, nil
 }
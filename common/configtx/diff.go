@@ -0,0 +1,227 @@
+/*
+Copyright IBM Corp. 2016-2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configtx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ValueInspection is returned by the Inspect(tx) method of a value
+// handler that implements valueInspector. Inspect is an optional method:
+// api.Transactional and config.ValueProposer do not declare it, and
+// SimulateConfig detects it by type assertion. Committed holds the values as they stand before
+// tx, Proposed holds them as they would stand were tx committed, and
+// PreviousGroups lists the subgroup names that were visible before tx
+// began.
+type ValueInspection struct {
+	Committed      map[string]proto.Message
+	Proposed       map[string]proto.Message
+	PreviousGroups []string
+}
+
+// PolicyInspection is the policies.Proposer analog of ValueInspection.
+type PolicyInspection struct {
+	Committed map[string]*cb.ConfigPolicy
+	Proposed  map[string]*cb.ConfigPolicy
+}
+
+// valueInspector is implemented by value handlers that support
+// SimulateConfig.
+type valueInspector interface {
+	Inspect(tx interface{}) ValueInspection
+}
+
+// policyInspector is implemented by policy handlers that support
+// SimulateConfig.
+type policyInspector interface {
+	Inspect(tx interface{}) PolicyInspection
+}
+
+// ValueDiff describes how a single config value within a group would
+// change. Old is nil when the value is being added, New is nil when it
+// is being removed.
+type ValueDiff struct {
+	Old proto.Message
+	New proto.Message
+}
+
+// PolicyDiff describes how a single config policy within a group would
+// change. Old is nil when the policy is being added, New is nil when it
+// is being removed.
+type PolicyDiff struct {
+	Old *cb.ConfigPolicy
+	New *cb.ConfigPolicy
+}
+
+// GroupDiff describes the changes a proposal would make to a single
+// group, keyed by the fully qualified group path (e.g.
+// "/Channel/Application/Org1").
+type GroupDiff struct {
+	Values        map[string]*ValueDiff
+	Policies      map[string]*PolicyDiff
+	AddedGroups   []string
+	RemovedGroups []string
+	// Handlers lists, by Go type, the handlers that accepted this
+	// group's proposed changes.
+	Handlers []string
+}
+
+// ConfigDiff is the result of configManager.SimulateConfig: the full set
+// of per-group changes a ConfigGroup would produce if it were actually
+// proposed and committed.
+type ConfigDiff struct {
+	Groups map[string]*GroupDiff
+}
+
+// SimulateConfig runs channelGroup through the same proposal recursion
+// processConfig uses - deserializing every value, invoking ProposePolicy,
+// running preCommit - but never commits it: the resulting tree is always
+// rolled back before SimulateConfig returns. In its place, SimulateConfig
+// reports a ConfigDiff describing what processConfig would have changed,
+// so operators can preview a channel update before submitting it as a
+// real config transaction.
+//
+// Until a handler implements valueInspector/policyInspector, none of
+// config.ValueProposer's or policies.Proposer's in-tree implementations
+// do, ConfigDiff cannot report what a group's values and policies looked
+// like before tx, or which subgroups tx removed: Old stays nil and
+// RemovedGroups stays empty for that group's handler. In that case the
+// returned GroupDiff is a snapshot of the proposed state (every New is
+// populated, sourced directly from proposeGroup's own deserialization)
+// rather than a true before/after diff. Handlers opt into the full diff
+// by implementing Inspect; SimulateConfig does not fail or skip a group
+// for lacking it.
+func (cm *configManager) SimulateConfig(channelGroup *cb.ConfigGroup) (*ConfigDiff, error) {
+	helperGroup := cb.NewConfigGroup()
+	helperGroup.Groups[RootGroupKey] = channelGroup
+	result, err := cm.proposeGroup(context.Background(), channelGroup, "", newConfigGroupWrapper(helperGroup), cm.initializer.ValueProposer(), cm.initializer.PolicyProposer())
+	if err != nil {
+		return nil, err
+	}
+	defer result.rollback()
+
+	if err := result.preCommit(); err != nil {
+		return nil, err
+	}
+
+	diff := &ConfigDiff{Groups: make(map[string]*GroupDiff)}
+	if root, ok := result.subResult(RootGroupKey); ok {
+		populateGroupDiff(diff, channelGroup, root)
+	}
+	return diff, nil
+}
+
+// populateGroupDiff walks group and its proposed result in lockstep,
+// recording the changes this level would produce before recursing into
+// subgroups. result.name is already the fully qualified path proposeGroup
+// built up (e.g. "/Channel/Application/Org1"), so it is used as the diff
+// key directly rather than re-accumulated here.
+//
+// Values and Policies are always populated from the data proposeGroup
+// itself already deserialized/proposed, so New is accurate even for
+// handlers that don't implement valueInspector/policyInspector. Old,
+// AddedGroups and RemovedGroups need to know what was committed before
+// tx, which only the handler can answer, so those stay empty unless the
+// handler opts in via Inspect.
+func populateGroupDiff(diff *ConfigDiff, group *cb.ConfigGroup, result *configResult) {
+	path := result.name
+	if path == "" {
+		path = "/"
+	}
+	groupDiff := &GroupDiff{
+		Values:   make(map[string]*ValueDiff),
+		Policies: make(map[string]*PolicyDiff),
+	}
+
+	for key := range group.Values {
+		groupDiff.Values[key] = &ValueDiff{New: result.deserializedValues[key]}
+	}
+	for key, policy := range group.Policies {
+		groupDiff.Policies[key] = &PolicyDiff{New: policy}
+	}
+
+	if inspector, ok := result.handler.(valueInspector); ok {
+		values := inspector.Inspect(result.tx)
+		for key := range group.Values {
+			groupDiff.Values[key].Old = values.Committed[key]
+			if proposed, ok := values.Proposed[key]; ok {
+				groupDiff.Values[key].New = proposed
+			}
+		}
+		for key, old := range values.Committed {
+			if _, stillPresent := group.Values[key]; !stillPresent {
+				groupDiff.Values[key] = &ValueDiff{Old: old}
+			}
+		}
+		for subName := range group.Groups {
+			if !contains(values.PreviousGroups, subName) {
+				groupDiff.AddedGroups = append(groupDiff.AddedGroups, subName)
+			}
+		}
+		for _, subName := range values.PreviousGroups {
+			if _, stillPresent := group.Groups[subName]; !stillPresent {
+				groupDiff.RemovedGroups = append(groupDiff.RemovedGroups, subName)
+			}
+		}
+	}
+	groupDiff.Handlers = append(groupDiff.Handlers, fmt.Sprintf("%T", result.handler))
+
+	if inspector, ok := result.policyHandler.(policyInspector); ok {
+		policyView := inspector.Inspect(result.tx)
+		for key := range group.Policies {
+			groupDiff.Policies[key].Old = policyView.Committed[key]
+			if proposed, ok := policyView.Proposed[key]; ok {
+				groupDiff.Policies[key].New = proposed
+			}
+		}
+		for key, old := range policyView.Committed {
+			if _, stillPresent := group.Policies[key]; !stillPresent {
+				groupDiff.Policies[key] = &PolicyDiff{Old: old}
+			}
+		}
+	}
+	groupDiff.Handlers = append(groupDiff.Handlers, fmt.Sprintf("%T", result.policyHandler))
+
+	diff.Groups[path] = groupDiff
+
+	for _, subResult := range result.subResults {
+		if subResult == nil {
+			continue
+		}
+		subName := strings.TrimPrefix(subResult.name, result.name+"/")
+		subGroup, ok := group.Groups[subName]
+		if !ok {
+			continue
+		}
+		populateGroupDiff(diff, subGroup, subResult)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+/*
+Copyright IBM Corp. 2016-2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configtx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LimiterPolicy controls what Limiter.Acquire does when a transaction
+// would exceed the configured rate.
+type LimiterPolicy int
+
+const (
+	// FastFail returns immediately with an error instead of admitting a
+	// transaction that would exceed the limit.
+	FastFail LimiterPolicy = iota
+	// Block waits, subject to the caller's context, until enough tokens
+	// have accrued to admit the transaction.
+	Block
+)
+
+// Limiter is a token-bucket rate limiter governing how frequently
+// processConfig may accept new channel config transactions. It is safe
+// for concurrent use.
+type Limiter struct {
+	mu         sync.Mutex
+	policy     LimiterPolicy
+	limit      float64 // bytes per second; <= 0 disables throttling
+	tokens     float64
+	lastRefill time.Time
+}
+
+// NewLimiter constructs a Limiter admitting up to bytesPerSecond on
+// average, applying policy when that rate would be exceeded.
+func NewLimiter(bytesPerSecond float64, policy LimiterPolicy) *Limiter {
+	return &Limiter{
+		policy:     policy,
+		limit:      bytesPerSecond,
+		tokens:     bytesPerSecond,
+		lastRefill: time.Now(),
+	}
+}
+
+// SetLimit updates the limiter's sustained rate (and burst ceiling) to
+// bytesPerSecond. A limit of zero or less disables throttling.
+func (l *Limiter) SetLimit(bytesPerSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = bytesPerSecond
+	if l.tokens > l.limit {
+		l.tokens = l.limit
+	}
+}
+
+// Allow reports whether a transaction of n bytes may proceed right now,
+// consuming n tokens from the bucket if so. Tokens are consumed even for
+// a transaction that is later rolled back, since the attempt itself
+// still counts against the rate.
+//
+// The bucket's ceiling is normally l.limit, but a single transaction
+// larger than that would otherwise never accrue enough tokens to be
+// served at all. Such a transaction instead raises the ceiling to its
+// own size for this call, so it remains servable - immediately, if
+// enough idle time has already elapsed, or once enough does - rather
+// than permanently rejected.
+func (l *Limiter) Allow(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit <= 0 {
+		return true
+	}
+
+	ceiling := l.limit
+	if float64(n) > ceiling {
+		ceiling = float64(n)
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.lastRefill).Seconds() * l.limit
+	if l.tokens > ceiling {
+		l.tokens = ceiling
+	}
+	l.lastRefill = now
+
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// Acquire admits a transaction of n bytes according to the limiter's
+// policy: FastFail returns an error immediately, Block waits until
+// either enough tokens accrue or ctx is done.
+func (l *Limiter) Acquire(ctx context.Context, n int) error {
+	if l.policy == Block {
+		for !l.Allow(n) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return nil
+	}
+
+	if !l.Allow(n) {
+		return fmt.Errorf("config transaction rejected: %d bytes exceeds the configured rate limit", n)
+	}
+	return nil
+}
+
+// Status is a point-in-time snapshot of a Monitor.
+type Status struct {
+	Active            bool
+	TotalBytes        uint64
+	Samples           uint64
+	Rejected          uint64
+	InstantaneousRate float64 // bytes/sec since the previous accepted sample
+	EMARate           float64 // exponential moving average of bytes/sec
+}
+
+// Monitor tracks the size, count, and EMA-smoothed rate of config
+// transactions accepted or rejected on a channel, modeled after the
+// streaming-rate monitors used elsewhere in Fabric to report transfer
+// throughput.
+type Monitor struct {
+	mu    sync.Mutex
+	alpha float64
+
+	active         bool
+	totalBytes     uint64
+	samples        uint64
+	rejected       uint64
+	lastSampleAt   time.Time
+	lastSampleRate float64
+	ema            float64
+}
+
+// NewMonitor constructs a Monitor whose EMA is smoothed with the given
+// weight (0, 1] on each new sample; smaller values smooth more.
+func NewMonitor(alpha float64) *Monitor {
+	return &Monitor{alpha: alpha}
+}
+
+func (m *Monitor) recordAccepted(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if !m.lastSampleAt.IsZero() {
+		if elapsed := now.Sub(m.lastSampleAt).Seconds(); elapsed > 0 {
+			m.lastSampleRate = float64(n) / elapsed
+			m.ema = m.alpha*m.lastSampleRate + (1-m.alpha)*m.ema
+		}
+	}
+	m.lastSampleAt = now
+	m.totalBytes += uint64(n)
+	m.samples++
+	m.active = true
+}
+
+func (m *Monitor) recordRejected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rejected++
+}
+
+// Status returns a snapshot of the monitor's current counters.
+func (m *Monitor) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Status{
+		Active:            m.active,
+		TotalBytes:        m.totalBytes,
+		Samples:           m.samples,
+		Rejected:          m.rejected,
+		InstantaneousRate: m.lastSampleRate,
+		EMARate:           m.ema,
+	}
+}
+
+// Monitor exposes the configManager's per-channel transfer monitor for
+// metrics scraping. It returns nil if no monitor is configured.
+func (cm *configManager) Monitor() *Monitor {
+	return cm.txMonitor
+}
+
+// rateLimitValueKey is the deserialized value key a channel uses to
+// configure its own config-transaction rate limit, making the limiter
+// self-governing from channel config rather than only from process
+// startup flags.
+const rateLimitValueKey = "ConfigTxRateLimit"
+
+// RateLimitValue is implemented by a deserialized config Value that
+// carries a channel-configured config-transaction rate limit.
+type RateLimitValue interface {
+	BytesPerSecond() float64
+}
+
+// applyChannelRateLimit updates cm's limiter from a freshly deserialized
+// rateLimitValueKey value, if one was present and the manager has a
+// limiter configured.
+func (cm *configManager) applyChannelRateLimit(msg interface{}) {
+	if cm.limiter == nil {
+		return
+	}
+	if rl, ok := msg.(RateLimitValue); ok {
+		cm.limiter.SetLimit(rl.BytesPerSecond())
+	}
+}
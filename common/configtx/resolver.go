@@ -0,0 +1,241 @@
+/*
+Copyright IBM Corp. 2016-2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configtx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// valueRefMagic prefixes the marshaled ValueRef bytes a ConfigGroup Value
+// carries when it is an external reference rather than an ordinary inline
+// value. Without this marker, tryParseValueRef would have to guess from
+// proto.Unmarshal succeeding alone, and an inline value that happens to
+// unmarshal as a field-1 string would be silently redirected to a
+// ValueResolver instead of used as-is. NewValueRef produces envelopes
+// carrying this marker; nothing else should construct one by hand.
+var valueRefMagic = []byte("fabric.configtx.ValueRef\x00")
+
+// ValueRef is the sentinel envelope a ConfigGroup Value carries instead
+// of its real bytes when those bytes live in an external store (Vault or
+// a local file) rather than the config transaction itself. A Value is
+// only treated as a ValueRef when tryParseValueRef recognizes its
+// valueRefMagic marker; any other value, however its bytes happen to
+// decode, is treated as an ordinary inline value.
+type ValueRef struct {
+	Uri string `protobuf:"bytes,1,opt,name=uri" json:"uri,omitempty"`
+}
+
+func (m *ValueRef) Reset()         { *m = ValueRef{} }
+func (m *ValueRef) String() string { return proto.CompactTextString(m) }
+func (m *ValueRef) ProtoMessage()  {}
+
+// NewValueRef marshals uri into the envelope tryParseValueRef recognizes,
+// for callers building a ConfigGroup Value that points at an external
+// store rather than carrying its value inline.
+func NewValueRef(uri string) ([]byte, error) {
+	marshaled, err := proto.Marshal(&ValueRef{Uri: uri})
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, valueRefMagic...), marshaled...), nil
+}
+
+// ValueResolver fetches the bytes referenced by a ValueRef's Uri. It is
+// consulted by proposeGroup before a value is handed to
+// valueDeserializer.Deserialize, and is the extension point operators use
+// to keep large MSP material or TLS roots out of config transactions
+// altogether.
+type ValueResolver interface {
+	Resolve(uri string) ([]byte, error)
+}
+
+// FileValueResolver resolves file:// URIs rooted at Root, the local half
+// of the local/remote split used elsewhere in Fabric for pluggable
+// secret backends. A Uri of file://tls/ca.pem resolves to
+// filepath.Join(Root, "tls/ca.pem").
+type FileValueResolver struct {
+	Root string
+}
+
+// Resolve implements ValueResolver.
+func (r *FileValueResolver) Resolve(uri string) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file value reference %s: %s", uri, err)
+	}
+	if u.Scheme != "file" {
+		return nil, fmt.Errorf("file value resolver cannot handle scheme %s", u.Scheme)
+	}
+
+	root := filepath.Clean(r.Root)
+	path := filepath.Join(root, filepath.Clean(filepath.Join(u.Host, u.Path)))
+	if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return nil, fmt.Errorf("file value reference %s escapes resolver root %s", uri, r.Root)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// KVStore is the minimal interface a remote key/value backend must
+// satisfy to back a RemoteValueResolver. A Vault adapter living
+// out-of-tree against Vault's KV secrets engine implements this.
+type KVStore interface {
+	Get(key string) ([]byte, error)
+}
+
+// RemoteValueResolver resolves URIs (typically vault://...) by
+// delegating to a KVStore keyed by the Uri's path.
+type RemoteValueResolver struct {
+	Store KVStore
+}
+
+// Resolve implements ValueResolver.
+func (r *RemoteValueResolver) Resolve(uri string) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote value reference %s: %s", uri, err)
+	}
+	return r.Store.Get(strings.TrimPrefix(u.Path, "/"))
+}
+
+// SchemeValueResolver dispatches Resolve to the ValueResolver registered
+// for the Uri's scheme, so a configManager can be handed both a
+// FileValueResolver and a RemoteValueResolver and have file:// and
+// vault:// references each route to the backend that handles them,
+// rather than being limited to a single ValueResolver for the whole
+// channel.
+type SchemeValueResolver struct {
+	resolvers map[string]ValueResolver
+}
+
+// NewSchemeValueResolver constructs a SchemeValueResolver dispatching
+// each scheme in resolvers to its associated ValueResolver.
+func NewSchemeValueResolver(resolvers map[string]ValueResolver) *SchemeValueResolver {
+	return &SchemeValueResolver{resolvers: resolvers}
+}
+
+// Resolve implements ValueResolver.
+func (r *SchemeValueResolver) Resolve(uri string) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value reference %s: %s", uri, err)
+	}
+
+	resolver, ok := r.resolvers[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("no value resolver registered for scheme %s", u.Scheme)
+	}
+	return resolver.Resolve(uri)
+}
+
+// resolvedValueCache memoizes ValueResolver fetches by Uri and a hash of
+// the referencing envelope, so that repeated proposals of the same
+// unchanged reference don't re-fetch from the backing store.
+type resolvedValueCache struct {
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+func newResolvedValueCache() *resolvedValueCache {
+	return &resolvedValueCache{cache: make(map[string][]byte)}
+}
+
+func (c *resolvedValueCache) resolve(resolver ValueResolver, uri string, envelope []byte) ([]byte, error) {
+	cacheKey := resolvedValueCacheKey(uri, envelope)
+
+	c.mu.Lock()
+	if cached, ok := c.cache[cacheKey]; ok {
+		c.mu.Unlock()
+		return cached, nil
+	}
+	c.mu.Unlock()
+
+	resolved, err := resolver.Resolve(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.cache[cacheKey] = resolved
+	c.mu.Unlock()
+	return resolved, nil
+}
+
+func resolvedValueCacheKey(uri string, envelope []byte) string {
+	sum := sha256.Sum256(envelope)
+	return uri + "#" + hex.EncodeToString(sum[:])
+}
+
+// tryParseValueRef reports whether raw is a ValueRef envelope pointing
+// at an external store, as opposed to an ordinary inline config value.
+// It requires the explicit valueRefMagic marker rather than attempting
+// proto.Unmarshal against every value, so an inline value whose bytes
+// happen to decode as a plausible-looking ValueRef is never mistaken for
+// one.
+func tryParseValueRef(raw []byte) (*ValueRef, bool) {
+	if !bytes.HasPrefix(raw, valueRefMagic) {
+		return nil, false
+	}
+
+	ref := &ValueRef{}
+	if err := proto.Unmarshal(raw[len(valueRefMagic):], ref); err != nil || ref.Uri == "" {
+		return nil, false
+	}
+
+	switch {
+	case strings.HasPrefix(ref.Uri, "vault://"),
+		strings.HasPrefix(ref.Uri, "file://"):
+		return ref, true
+	default:
+		return nil, false
+	}
+}
+
+// resolveValue returns the bytes proposeGroup should deserialize for a
+// config value: raw itself when it is not an external reference, or the
+// Initializer's ValueResolver's fetch of the referenced Uri otherwise.
+// The ValueResolver is sourced from cm.initializer, the same place
+// proposeGroup gets its ValueProposer/PolicyProposer, rather than being a
+// standalone configManager field. An Initializer that needs both file://
+// and vault:// references to work returns a SchemeValueResolver wrapping
+// a FileValueResolver and a RemoteValueResolver rather than either alone.
+func (cm *configManager) resolveValue(raw []byte) ([]byte, error) {
+	ref, ok := tryParseValueRef(raw)
+	if !ok {
+		return raw, nil
+	}
+
+	resolver := cm.initializer.ValueResolver()
+	if resolver == nil {
+		return nil, fmt.Errorf("config value references external uri %s but no ValueResolver is configured", ref.Uri)
+	}
+
+	cm.resolverCacheOnce.Do(func() {
+		cm.resolverCache = newResolvedValueCache()
+	})
+
+	return cm.resolverCache.resolve(resolver, ref.Uri, raw)
+}